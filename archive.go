@@ -0,0 +1,79 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+)
+
+// buildContextTar tars up projectDir into the stream cli.ImageBuild
+// expects, honoring .dockerignore the same way `docker build` does. This
+// replaces the old os.Open(projectPath), which handed ImageBuild a
+// directory handle instead of a tar reader.
+func buildContextTar(projectDir string) (io.ReadCloser, error) {
+	var excludes []string
+
+	ignoreFile, err := os.Open(filepath.Join(projectDir, ".dockerignore"))
+	switch {
+	case err == nil:
+		defer ignoreFile.Close()
+		excludes, err = dockerignore.ReadAll(ignoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse .dockerignore: %v", err)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read .dockerignore: %v", err)
+	}
+
+	tarStream, err := archive.TarWithOptions(projectDir, &archive.TarOptions{ExcludePatterns: excludes})
+	if err != nil {
+		return nil, fmt.Errorf("failed to tar project directory: %v", err)
+	}
+	return tarStream, nil
+}
+
+// fetchRemoteBuildContext mirrors Docker's `remote` build parameter: a
+// tarball URL is streamed straight into ImageBuild, while a bare
+// Dockerfile URL (Content-Type: text/plain) is wrapped into a minimal tar
+// first, since the Docker API always expects a tar stream as its context.
+func fetchRemoteBuildContext(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remote build context: %v", err)
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		defer resp.Body.Close()
+		content, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote Dockerfile: %v", err)
+		}
+		return wrapDockerfileInTar(content), nil
+	}
+
+	return resp.Body, nil
+}
+
+// wrapDockerfileInTar packages a single Dockerfile's contents into a tar
+// stream, the same trick Docker's own archive.Generate uses for ad-hoc
+// single-file build contexts.
+func wrapDockerfileInTar(dockerfile []byte) io.ReadCloser {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{
+		Name: "Dockerfile",
+		Mode: 0644,
+		Size: int64(len(dockerfile)),
+	})
+	tw.Write(dockerfile)
+	tw.Close()
+	return io.NopCloser(buf)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+)
+
+// cacheMountFor maps a provider to the persistent ezdeploy-<tool> named
+// volume that should be mounted into the dependency-warming container
+// runProviderBuild starts, so repeat builds don't re-download the same
+// Maven/Gradle/npm packages.
+func cacheMountFor(providerName string) (id, mountPath string) {
+	switch providerName {
+	case "maven":
+		return "ezdeploy-m2", "/root/.m2"
+	case "gradle":
+		return "ezdeploy-gradle", "/root/.gradle"
+	case "node":
+		return "ezdeploy-npm", "/root/.npm"
+	default:
+		return "", ""
+	}
+}
+
+// runProviderBuild runs a provider's InstallSteps and BuildSteps directly
+// against the checkout, in a container that bind-mounts projectDir at
+// /app and, when the provider has one, mounts its persistent cache volume
+// (see cacheMountFor). That container is classic-builder-only (no
+// `RUN --mount`), so its cache actually survives across builds instead of
+// silently no-op'ing the way a BuildKit-only Dockerfile instruction would
+// under the daemon's default builder. The artifacts land back in
+// projectDir on the host, ready for renderDockerfile's COPY to pick up.
+func runProviderBuild(plan BuildPlan, providerName, projectDir string, sf *StreamFormatter) error {
+	steps := append(append([]string{}, plan.InstallSteps...), plan.BuildSteps...)
+	if len(steps) == 0 {
+		return nil
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Docker client: %v", err)
+	}
+
+	absProjectDir, err := filepath.Abs(projectDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve project directory: %v", err)
+	}
+
+	mounts := []mount.Mount{{Type: mount.TypeBind, Source: absProjectDir, Target: "/app"}}
+	if cacheID, cachePath := cacheMountFor(providerName); cacheID != "" {
+		mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Source: cacheID, Target: cachePath})
+	}
+
+	containerConfig := &container.Config{
+		Image:      plan.BaseImage,
+		WorkingDir: "/app",
+		Cmd:        []string{"/bin/sh", "-c", strings.Join(steps, " && ")},
+	}
+	hostConfig := &container.HostConfig{Mounts: mounts}
+
+	sf.Status("warming-dependency-cache")
+	containerResp, err := cli.ContainerCreate(context.Background(), containerConfig, hostConfig, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create dependency build container: %v", err)
+	}
+	defer cli.ContainerRemove(context.Background(), containerResp.ID, container.RemoveOptions{Force: true})
+
+	if err := cli.ContainerStart(context.Background(), containerResp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start dependency build container: %v", err)
+	}
+
+	logReader, err := cli.ContainerLogs(context.Background(), containerResp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err == nil {
+		go func() {
+			defer logReader.Close()
+			logScanner := bufio.NewScanner(logReader)
+			for logScanner.Scan() {
+				sf.Stream("warming-dependency-cache", logScanner.Text())
+			}
+		}()
+	}
+
+	statusCh, errCh := cli.ContainerWait(context.Background(), containerResp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("error while waiting for dependency build container: %v", err)
+		}
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("dependency build exited with non-zero status: %v", status.StatusCode)
+		}
+	}
+	return nil
+}
+
+// imageTagCache remembers the most recently built image tag per repo
+// slug, so the next build can pass it as CacheFrom and reuse layers
+// instead of rebuilding from scratch.
+type imageTagCache struct {
+	mu   sync.Mutex
+	tags map[string]string
+}
+
+var lastBuiltImage = &imageTagCache{tags: make(map[string]string)}
+
+func (c *imageTagCache) get(slug string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tag, ok := c.tags[slug]
+	return tag, ok
+}
+
+func (c *imageTagCache) set(slug, tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tags[slug] = tag
+}
+
+// imageTag names an image the way every ezdeploy build is tagged:
+// ezdeploy/<slug>:<shortsha>.
+func imageTag(slug, commitSHA string) string {
+	shortSHA := commitSHA
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+	if shortSHA == "" {
+		shortSHA = "latest"
+	}
+	return fmt.Sprintf("ezdeploy/%s:%s", slug, shortSHA)
+}
+
+// cacheStatsHandler reports Docker's build cache usage, the same numbers
+// `docker system df` surfaces.
+func cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to initialize Docker client: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	usage, err := cli.DiskUsage(context.Background(), types.DiskUsageOptions{})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read disk usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage.BuildCache)
+}
+
+// cachePruneHandler drops unused build cache and stale named volumes, for
+// when disk pressure matters more than fast repeat builds.
+func cachePruneHandler(w http.ResponseWriter, r *http.Request) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to initialize Docker client: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	buildReport, err := cli.BuildCachePrune(context.Background(), types.BuildCachePruneOptions{All: true})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to prune build cache: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	volumeReport, err := cli.VolumesPrune(context.Background(), filters.Args{})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to prune volumes: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"spaceReclaimed":   buildReport.SpaceReclaimed,
+		"volumesDeleted":   volumeReport.VolumesDeleted,
+		"volumesReclaimed": volumeReport.SpaceReclaimed,
+	})
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/gorilla/mux"
+)
+
+type DeploymentStatus string
+
+const (
+	DeploymentRunning DeploymentStatus = "running"
+	DeploymentStopped DeploymentStatus = "stopped"
+)
+
+// Deployment is a single running (or stopped) app ezdeploy has built and
+// started, tracked so /deployments and the reverse proxy can find it.
+type Deployment struct {
+	ID          string           `json:"id"`
+	Repo        string           `json:"repo"`
+	CommitSHA   string           `json:"commitSHA"`
+	ContainerID string           `json:"containerId"`
+	Host        string           `json:"host"`
+	Port        int              `json:"port"`
+	Status      DeploymentStatus `json:"status"`
+}
+
+// DeploymentRegistry tracks every deployment ezdeploy has run, in memory,
+// keyed by deployment ID.
+type DeploymentRegistry struct {
+	mu          sync.Mutex
+	deployments map[string]*Deployment
+}
+
+var deployments = &DeploymentRegistry{deployments: make(map[string]*Deployment)}
+
+func (r *DeploymentRegistry) put(d *Deployment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deployments[d.ID] = d
+}
+
+func (r *DeploymentRegistry) get(id string) (*Deployment, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.deployments[id]
+	return d, ok
+}
+
+func (r *DeploymentRegistry) list() []*Deployment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]*Deployment, 0, len(r.deployments))
+	for _, d := range r.deployments {
+		list = append(list, d)
+	}
+	return list
+}
+
+func (r *DeploymentRegistry) delete(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.deployments, id)
+}
+
+// runApp starts imageTag as a long-lived container: published ports, a
+// restart policy, CPU/memory quotas, and env vars from the deploy request
+// (falling back to .ezdeploy.yml). The resulting Deployment is recorded in
+// the registry.
+//
+// imageTag still points at the build image (maven/gradle/node/golang),
+// not a slim runtime base — it's the image that ran InstallSteps and
+// BuildSteps, so it has everything the artifact needs to run. It only has
+// something to exec, though, when plan.SelfRunning (the base image serves
+// on its own, e.g. nginx) or a run command was inferred or configured;
+// otherwise there's nothing for the container to do but exit, so runApp
+// fails loudly instead of silently starting a dead container.
+func runApp(deploymentID, repoName, commitSHA, imageTag string, req RepoRequest, cfg *EzdeployConfig, plan BuildPlan, sf *StreamFormatter) (*Deployment, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Docker client: %v", err)
+	}
+
+	runCmd := runCommandFor(cfg, plan)
+	if runCmd == "" && !plan.SelfRunning {
+		return nil, fmt.Errorf("no run command: set `run` in .ezdeploy.yml to start %s", repoName)
+	}
+
+	containerPort, err := nat.NewPort("tcp", fmt.Sprintf("%d", containerPortFor(req, cfg)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid container port: %v", err)
+	}
+
+	containerConfig := &container.Config{
+		Image:        imageTag,
+		Env:          envSliceFor(req, cfg),
+		ExposedPorts: nat.PortSet{containerPort: struct{}{}},
+		Healthcheck:  healthConfigFor(cfg),
+	}
+	if runCmd != "" {
+		containerConfig.Cmd = []string{"/bin/sh", "-c", runCmd}
+	}
+
+	hostConfig := &container.HostConfig{
+		PortBindings:  nat.PortMap{containerPort: []nat.PortBinding{{HostIP: "0.0.0.0"}}},
+		RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+		Resources: container.Resources{
+			NanoCPUs: 1_000_000_000,     // 1 CPU
+			Memory:   512 * 1024 * 1024, // 512MB
+		},
+	}
+
+	sf.Status("starting-container")
+	containerResp, err := cli.ContainerCreate(context.Background(), containerConfig, hostConfig, nil, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run container: %v", err)
+	}
+
+	if err := cli.ContainerStart(context.Background(), containerResp.ID, container.StartOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to start run container: %v", err)
+	}
+
+	hostPort := containerPort.Int()
+	if inspect, err := cli.ContainerInspect(context.Background(), containerResp.ID); err == nil {
+		if bindings, ok := inspect.NetworkSettings.Ports[containerPort]; ok && len(bindings) > 0 {
+			fmt.Sscanf(bindings[0].HostPort, "%d", &hostPort)
+		}
+	}
+
+	d := &Deployment{
+		ID:          deploymentID,
+		Repo:        repoName,
+		CommitSHA:   commitSHA,
+		ContainerID: containerResp.ID,
+		Host:        deploymentSlug(repoName),
+		Port:        hostPort,
+		Status:      DeploymentRunning,
+	}
+	deployments.put(d)
+	sf.Status("running")
+	return d, nil
+}
+
+func containerPortFor(req RepoRequest, cfg *EzdeployConfig) int {
+	if len(req.Ports) > 0 {
+		return req.Ports[0]
+	}
+	if cfg != nil && len(cfg.Ports) > 0 {
+		return cfg.Ports[0]
+	}
+	return 8080
+}
+
+// runCommandFor resolves the run phase's start command: an explicit
+// `run` in .ezdeploy.yml always wins over the provider's own guess.
+func runCommandFor(cfg *EzdeployConfig, plan BuildPlan) string {
+	if cfg != nil && cfg.Run != "" {
+		return cfg.Run
+	}
+	return plan.RunCommand
+}
+
+// healthConfigFor turns .ezdeploy.yml's healthcheck string, if any, into
+// the CMD-SHELL health check Docker polls the container with. cfg is nil,
+// or Healthcheck is empty, when a repo doesn't configure one — in which
+// case this is nil too, and Docker falls back to reporting the container
+// healthy whenever it's merely running.
+func healthConfigFor(cfg *EzdeployConfig) *container.HealthConfig {
+	if cfg == nil || cfg.Healthcheck == "" {
+		return nil
+	}
+	return &container.HealthConfig{
+		Test:     []string{"CMD-SHELL", cfg.Healthcheck},
+		Interval: 30 * time.Second,
+		Timeout:  5 * time.Second,
+		Retries:  3,
+	}
+}
+
+// envSliceFor merges .ezdeploy.yml's env with the deploy request's env
+// (the request wins on key collisions) into the KEY=VALUE slice
+// container.Config expects.
+func envSliceFor(req RepoRequest, cfg *EzdeployConfig) []string {
+	env := map[string]string{}
+	if cfg != nil {
+		for k, v := range cfg.Env {
+			env[k] = v
+		}
+	}
+	for k, v := range req.Env {
+		env[k] = v
+	}
+
+	slice := make([]string, 0, len(env))
+	for k, v := range env {
+		slice = append(slice, k+"="+v)
+	}
+	return slice
+}
+
+// deploymentSlug is the hostname a deployment answers to behind the
+// reverse proxy, e.g. "myapp" for "myapp.localhost".
+func deploymentSlug(repoName string) string {
+	return strings.ToLower(repoName)
+}
+
+func listDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deployments.list())
+}
+
+func getDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	d, ok := deployments.get(id)
+	if !ok {
+		http.Error(w, "unknown deployment id", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+func stopDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	d, ok := deployments.get(id)
+	if !ok {
+		http.Error(w, "unknown deployment id", http.StatusNotFound)
+		return
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to initialize Docker client: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := cli.ContainerStop(context.Background(), d.ContainerID, container.StopOptions{}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to stop container: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	d.Status = DeploymentStopped
+	deployments.put(d)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	d, ok := deployments.get(id)
+	if !ok {
+		http.Error(w, "unknown deployment id", http.StatusNotFound)
+		return
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to initialize Docker client: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := cli.ContainerRemove(context.Background(), d.ContainerID, container.RemoveOptions{Force: true}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to remove container: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	deployments.delete(id)
+	w.WriteHeader(http.StatusNoContent)
+}
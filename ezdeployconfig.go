@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EzdeployConfig is the optional .ezdeploy.yml a repo can ship to describe
+// build args, exposed ports, env vars, a healthcheck, and a post-build run
+// command, instead of relying purely on what the detected BuildProvider
+// infers.
+type EzdeployConfig struct {
+	BuildArgs   map[string]string `yaml:"buildArgs"`
+	Ports       []int             `yaml:"ports"`
+	Env         map[string]string `yaml:"env"`
+	Healthcheck string            `yaml:"healthcheck"`
+	Run         string            `yaml:"run"`
+}
+
+// loadEzdeployConfig reads .ezdeploy.yml from projectDir. It returns a nil
+// config, not an error, when the file simply doesn't exist.
+func loadEzdeployConfig(projectDir string) (*EzdeployConfig, error) {
+	data, err := os.ReadFile(filepath.Join(projectDir, ".ezdeploy.yml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .ezdeploy.yml: %v", err)
+	}
+
+	var cfg EzdeployConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse .ezdeploy.yml: %v", err)
+	}
+	return &cfg, nil
+}
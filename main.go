@@ -1,14 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"path/filepath"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -17,16 +18,44 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
 type RepoRequest struct {
 	RepoURL string `json:"repoURL"`
+
+	// Branch is cloned instead of the repo's default branch when set,
+	// e.g. the branch a GitHub/GitLab push webhook fired for.
+	Branch string `json:"branch,omitempty"`
+
+	// BuildContextURL, when set, bypasses cloning entirely: it's fetched
+	// as a remote Docker build context (tarball or bare Dockerfile URL),
+	// analogous to `docker build <url>`.
+	BuildContextURL string `json:"buildContextURL,omitempty"`
+
+	// Ports and Env configure the run phase: which container port(s) to
+	// publish and what environment to start the app with. Ports[0] wins
+	// when several are given; .ezdeploy.yml is used as a fallback.
+	Ports []int             `json:"ports,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
 }
 
-func fetchCodeFromGitHub(repoURL string, projectDir string) error {
+func fetchCodeFromGitHub(repoURL, branch, projectDir string) error {
 	// Clone the GitHub repository into the dynamically created directory
-	cmd := exec.Command("git", "clone", repoURL, projectDir)
+	args := []string{"clone"}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	args = append(args, repoURL, projectDir)
+
+	cmd := exec.Command("git", args...)
 	err := cmd.Run()
 	if err != nil {
 		return fmt.Errorf("failed to clone repo: %v", err)
@@ -74,75 +103,262 @@ func deployHandler(w http.ResponseWriter, r *http.Request) {
 	repoName := getRepoNameFromURL(req.RepoURL)
 
 	if repoName == "" {
-		fmt.Println("Failed to extract repository name from URL.")
+		http.Error(w, "Failed to extract repository name from URL.", http.StatusBadRequest)
 		return
 	}
 	fmt.Println("Extracted repository name:", repoName)
-	// Create a dynamic directory for the project
+
+	// Hand off to the same build queue git push and the webhooks use, so
+	// the caller can immediately start watching /deploy/{id}/events
+	// without racing the build.
+	deploymentID := enqueueDeploy(req, repoName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"deploymentId": deploymentID})
+}
+
+// runDeploy drives clone -> detect -> build -> run for one deployment,
+// reporting progress to sf/deployLog at each phase, and closes the log
+// when done.
+func runDeploy(req RepoRequest, repoName, deploymentID string, deployLog *deploymentLog, sf *StreamFormatter) {
+	defer deployLog.close()
+
+	if req.BuildContextURL != "" {
+		if err := buildFromRemoteContext(req.BuildContextURL, sf); err != nil {
+			sf.Error("build", err)
+			return
+		}
+		sf.Status("complete")
+		return
+	}
+
+	sf.Status("cloning")
 	projectDir := createProjectDir(repoName)
 	if projectDir == "" {
-		fmt.Println("Failed to create project directory.")
+		sf.Error("cloning", fmt.Errorf("failed to create project directory"))
+		return
+	}
+
+	if err := fetchCodeFromGitHub(req.RepoURL, req.Branch, projectDir); err != nil {
+		sf.Error("cloning", err)
 		return
 	}
-	fmt.Println("Project directory created:", projectDir)
+	sf.Status("cloned")
 
-	// Clone the repository into the dynamically created directory
-	err := fetchCodeFromGitHub(req.RepoURL, projectDir)
+	commitSHA, err := getCommitSHA(projectDir)
 	if err != nil {
-		fmt.Printf("Error fetching code: %v\n", err)
+		fmt.Println("failed to resolve commit SHA:", err)
+	}
+
+	cfg, err := loadEzdeployConfig(projectDir)
+	if err != nil {
+		sf.Error("config", err)
 		return
 	}
 
-	buildTool := detectBuildTool(projectDir)
+	// A repo-provided Dockerfile takes priority over provider detection.
+	var provider BuildProvider
+	var plan BuildPlan
+	useRepoDockerfile := fileExists(filepath.Join(projectDir, "Dockerfile"))
+	if useRepoDockerfile {
+		sf.Status("using-repo-dockerfile")
+		// The repo's own Dockerfile declares its own ENTRYPOINT/CMD.
+		plan = BuildPlan{ArtifactPath: ".", SelfRunning: true}
+	} else {
+		p, ok := DetectProvider(projectDir)
+		if !ok {
+			sf.Error("detect", fmt.Errorf("no build provider recognized this project"))
+			return
+		}
+		provider = p
+		sf.Status("detected-" + provider.Name())
+		plan = provider.Plan(projectDir)
+	}
 
-	if buildTool == "unknown" {
-		fmt.Println("Unsupported build tool!")
-		http.Error(w, fmt.Sprintf("Unsupported build tool! %v"), http.StatusBadRequest)
+	slug := deploymentSlug(repoName)
+	tag, err := executeBuild(projectDir, provider, plan, useRepoDockerfile, cfg, slug, commitSHA, sf)
+	if err != nil {
+		sf.Error("build", err)
+		return
+	}
+	lastBuiltImage.set(slug, tag)
+
+	if _, err := runApp(deploymentID, repoName, commitSHA, tag, req, cfg, plan, sf); err != nil {
+		sf.Error("run", err)
+		return
+	}
+
+	sf.Status("complete")
+}
+
+// getCommitSHA resolves HEAD of a freshly cloned repo, recorded alongside
+// the deployment so /deployments can show exactly what's running.
+func getCommitSHA(projectDir string) (string, error) {
+	cmd := exec.Command("git", "-C", projectDir, "rev-parse", "HEAD")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve commit SHA: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// deployEventsHandler streams a running/finished deployment's progress as
+// Server-Sent Events.
+func deployEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	deployLog, ok := deployLogs.get(id)
+	if !ok {
+		http.Error(w, "unknown deployment id", http.StatusNotFound)
 		return
 	}
 
-	// Execute the build inside a Docker container
-	fetchErr := executeBuild(projectDir, buildTool)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
 
-	if fetchErr != nil {
-		fmt.Printf("Build failed: %v\n", err)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for line := range deployLog.subscribe() {
+		fmt.Fprintf(w, "data: %s\n\n", strings.TrimRight(line, "\n"))
+		flusher.Flush()
+	}
+}
+
+// deployWebSocketHandler is the WebSocket equivalent of deployEventsHandler,
+// for frontends that prefer a socket over SSE.
+func deployWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	deployLog, ok := deployLogs.get(id)
+	if !ok {
+		http.Error(w, "unknown deployment id", http.StatusNotFound)
 		return
 	}
 
-	fmt.Println("Build completed successfully!")
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println("websocket upgrade failed:", err)
+		return
+	}
+	defer conn.Close()
+
+	for line := range deployLog.subscribe() {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.TrimRight(line, "\n"))); err != nil {
+			return
+		}
+	}
 }
 
-func executeBuild(projectPath string, buildTool string) error {
-	// Initialize Docker client
+// buildArgsFromConfig adapts .ezdeploy.yml's buildArgs map to the
+// map[string]*string shape types.ImageBuildOptions expects.
+func buildArgsFromConfig(cfg *EzdeployConfig) map[string]*string {
+	if cfg == nil {
+		return nil
+	}
+	args := make(map[string]*string, len(cfg.BuildArgs))
+	for k, v := range cfg.BuildArgs {
+		v := v
+		args[k] = &v
+	}
+	return args
+}
+
+// buildFromRemoteContext builds directly from a remote tarball or bare
+// Dockerfile URL, bypassing clone/detect entirely.
+func buildFromRemoteContext(contextURL string, sf *StreamFormatter) error {
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return fmt.Errorf("failed to initialize Docker client: %v", err)
 	}
-	// Generate Dockerfile
-	err = generateDockerfile(buildTool)
+
+	sf.Status("fetching-remote-context")
+	buildContext, err := fetchRemoteBuildContext(contextURL)
 	if err != nil {
 		return err
 	}
-	// Build the Docker image
-	buildContext, err := os.Open(projectPath)
+	defer buildContext.Close()
+
+	sf.Status("building-image")
+	buildResp, err := cli.ImageBuild(context.Background(), buildContext, types.ImageBuildOptions{
+		Dockerfile: "Dockerfile",
+		Tags:       []string{"ezdeploy-build-env"},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open project directory: %v", err)
+		return fmt.Errorf("failed to build Docker image: %v", err)
+	}
+	defer buildResp.Body.Close()
+
+	scanner := bufio.NewScanner(buildResp.Body)
+	for scanner.Scan() {
+		sf.Stream("building-image", scanner.Text())
+	}
+	return nil
+}
+
+func executeBuild(projectPath string, provider BuildProvider, plan BuildPlan, useRepoDockerfile bool, cfg *EzdeployConfig, repoSlug, commitSHA string, sf *StreamFormatter) (string, error) {
+	// Initialize Docker client
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize Docker client: %v", err)
+	}
+
+	if useRepoDockerfile {
+		sf.Status("using-repo-dockerfile")
+	} else {
+		if err := runProviderBuild(plan, provider.Name(), projectPath, sf); err != nil {
+			return "", err
+		}
+		sf.Status("generating-dockerfile")
+		if err := generateDockerfile(provider, plan, projectPath); err != nil {
+			return "", err
+		}
+	}
+
+	buildContext, err := buildContextTar(projectPath)
+	if err != nil {
+		return "", err
 	}
 	defer buildContext.Close()
+
+	// Tag per repo+commit and reuse the previous successful build for
+	// this repo as CacheFrom, so Docker can skip layers that didn't
+	// change.
+	tag := imageTag(repoSlug, commitSHA)
+	var cacheFrom []string
+	if previousTag, ok := lastBuiltImage.get(repoSlug); ok {
+		cacheFrom = []string{previousTag}
+	}
+
+	sf.Status("building-image")
 	buildResp, err := cli.ImageBuild(context.Background(), buildContext, types.ImageBuildOptions{
 		Dockerfile: "Dockerfile",
-		Tags:       []string{"java-build-env"},
+		Tags:       []string{tag, "ezdeploy-build-env"},
+		BuildArgs:  buildArgsFromConfig(cfg),
+		CacheFrom:  cacheFrom,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to build Docker image: %v", err)
+		return "", fmt.Errorf("failed to build Docker image: %v", err)
 	}
 	defer buildResp.Body.Close()
 
-	// Create container configuration
+	// Forward the raw jsonmessage stream from the build so callers see
+	// each build step as it happens, not just the final result.
+	scanner := bufio.NewScanner(buildResp.Body)
+	for scanner.Scan() {
+		sf.Stream("building-image", scanner.Text())
+	}
+
+	// Install and build steps already ran before the image build (in
+	// runProviderBuild, or in the repo's own Dockerfile), so the container
+	// only needs to exist long enough to copy the artifact back out of it.
 	containerConfig := &container.Config{
-		Image: "java-build-env", // Use the built image
-		Cmd:   []string{"/bin/sh", "-c", fmt.Sprintf("%s %s", buildTool, buildToolArgs(buildTool))},
+		Image: tag,
+		Cmd:   []string{"/bin/sh", "-c", "true"},
 	}
 
 	// Host configuration (optional, can be set to nil for default)
@@ -157,13 +373,31 @@ func executeBuild(projectPath string, buildTool string) error {
 	// Create the container
 	containerResp, err := cli.ContainerCreate(context.Background(), containerConfig, hostConfig, networkingConfig, platform, "")
 	if err != nil {
-		return fmt.Errorf("failed to create container: %v", err)
+		return "", fmt.Errorf("failed to create container: %v", err)
 	}
 
 	// Start the container
+	sf.Status("running-build-container")
 	err = cli.ContainerStart(context.Background(), containerResp.ID, container.StartOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to start container: %v", err)
+		return "", fmt.Errorf("failed to start container: %v", err)
+	}
+
+	// Follow the container's logs and forward them as they're produced,
+	// rather than waiting silently for it to exit.
+	logReader, err := cli.ContainerLogs(context.Background(), containerResp.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err == nil {
+		go func() {
+			defer logReader.Close()
+			logScanner := bufio.NewScanner(logReader)
+			for logScanner.Scan() {
+				sf.Stream("running-build-container", logScanner.Text())
+			}
+		}()
 	}
 
 	// Wait for the container to finish
@@ -171,93 +405,81 @@ func executeBuild(projectPath string, buildTool string) error {
 	select {
 	case err := <-errCh:
 		if err != nil {
-			return fmt.Errorf("error while waiting for container: %v", err)
+			return "", fmt.Errorf("error while waiting for container: %v", err)
 		}
 	case status := <-statusCh:
 		if status.StatusCode != 0 {
-			return fmt.Errorf("container exited with non-zero status: %v", status.StatusCode)
+			return "", fmt.Errorf("container exited with non-zero status: %v", status.StatusCode)
 		}
 	}
 
-	// Extract build artifacts (assuming JAR is at /app/target)
-	copyResp, _, err := cli.CopyFromContainer(context.Background(), containerResp.ID, "/app/target")
+	// Extract build artifacts from the path the provider's plan points at
+	sf.Status("extracting-artifacts")
+	copyResp, _, err := cli.CopyFromContainer(context.Background(), containerResp.ID, filepath.Join("/app", plan.ArtifactPath))
 	if err != nil {
-		return fmt.Errorf("failed to copy build artifacts: %v", err)
+		return "", fmt.Errorf("failed to copy build artifacts: %v", err)
 	}
 	defer copyResp.Close()
 
 	// Handle copying to host (e.g., extracting the artifact to /build-output)
 	err = os.MkdirAll("build-output", os.ModePerm)
 	if err != nil {
-		return fmt.Errorf("failed to create build output directory: %v", err)
+		return "", fmt.Errorf("failed to create build output directory: %v", err)
 	}
 
 	// Copy file from container to host
 	// You can use copy libraries or implement custom extraction logic
-	return nil
-
+	return tag, nil
 }
 
-func buildToolArgs(buildTool string) string {
-	if buildTool == "maven" {
-		return "clean package"
-	}
-	return "build"
-}
+// generateDockerfile renders the provider's plan and writes it into
+// projectDir, so it lands inside that project's own build context instead
+// of a previous bug that wrote to the server's CWD and let parallel
+// deploys clobber each other's Dockerfile.
+func generateDockerfile(provider BuildProvider, plan BuildPlan, projectDir string) error {
+	dockerfileContent := provider.Dockerfile(plan)
 
-func generateDockerfile(buildTool string) error {
-	dockerfileContent := `
-	FROM openjdk:17-jdk-slim
-	RUN apt-get update && apt-get install -y curl git`
-
-	// Add Maven or Gradle installation based on the build tool
-	if buildTool == "maven" {
-		dockerfileContent += "\nRUN apt-get install -y maven"
-	} else if buildTool == "gradle" {
-		dockerfileContent += "\nRUN apt-get install -y gradle"
-	}
-	// Set working directory in container
-	dockerfileContent += `
-	WORKDIR /app
-	COPY . .`
-
-	// Write Dockerfile to disk
-	err := os.WriteFile("Dockerfile", []byte(dockerfileContent), 0644)
+	err := os.WriteFile(filepath.Join(projectDir, "Dockerfile"), []byte(dockerfileContent), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write Dockerfile: %v", err)
 	}
 
 	return nil
 }
-func detectBuildTool(projectPath string) string {
-	// Check if pom.xml exists
-	pomPath := filepath.Join(projectPath, "pom.xml")
-	if _, err := os.Stat(pomPath); err == nil {
-		return "maven"
-	} else if os.IsNotExist(err) {
-		fmt.Println("pom.xml does not exist at", pomPath)
-	} else {
-		fmt.Println("Error checking pom.xml:", err)
-	}
-
-	// Check if build.gradle exists
-	gradlePath := filepath.Join(projectPath, "build.gradle")
-	if _, err := os.Stat(gradlePath); err == nil {
-		return "gradle"
-	} else if os.IsNotExist(err) {
-		fmt.Println("build.gradle does not exist at", gradlePath)
-	} else {
-		fmt.Println("Error checking build.gradle:", err)
-	}
-
-	// Return unknown if neither file is found
-	return "unknown"
-}
 
 func main() {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/deploy/{repoURL}", deployHandler).Methods("POST").Schemes("http")
+	r.HandleFunc("/deploy/{id}/events", deployEventsHandler).Methods("GET")
+	r.HandleFunc("/deploy/{id}/ws", deployWebSocketHandler).Methods("GET")
+
+	r.HandleFunc("/deployments", listDeploymentsHandler).Methods("GET")
+	r.HandleFunc("/deployments/{id}", getDeploymentHandler).Methods("GET")
+	r.HandleFunc("/deployments/{id}/stop", stopDeploymentHandler).Methods("POST")
+	r.HandleFunc("/deployments/{id}", deleteDeploymentHandler).Methods("DELETE")
+
+	r.HandleFunc("/webhooks/github", githubWebhookHandler).Methods("POST")
+	r.HandleFunc("/webhooks/gitlab", gitlabWebhookHandler).Methods("POST")
+
+	r.HandleFunc("/cache/stats", cacheStatsHandler).Methods("GET")
+	r.HandleFunc("/cache/prune", cachePruneHandler).Methods("POST")
+
+	// The reverse proxy runs on its own port so every deployment can be
+	// reached through one address via Host-based routing, independent of
+	// the control-plane API above.
+	go func() {
+		fmt.Println("Starting reverse proxy on port 9090...")
+		log.Fatal(http.ListenAndServe(":9090", newReverseProxy()))
+	}()
+
+	// git push ezdeploy@host:<slug> is an alternative to POST /deploy,
+	// funneling into the same build queue.
+	go func() {
+		if err := startSSHServer(":2222"); err != nil {
+			fmt.Println("ssh server error:", err)
+		}
+	}()
 
 	fmt.Println("Starting server on port 8080...")
 	log.Fatal(http.ListenAndServe(":8080", r))
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// BuildPlan describes how to turn a project checkout into a runnable image:
+// what to start FROM, how to install dependencies, how to build, and where
+// the resulting artifact ends up. InstallSteps and BuildSteps run against
+// the checkout itself, before the image is built — see runProviderBuild.
+//
+// RunCommand is the provider's best guess at how to start the built
+// artifact; it's empty when the provider can't infer one (Maven/Gradle
+// produce a jar, not an entry point). SelfRunning is true when BaseImage
+// already runs something on container start without a command — e.g.
+// nginx serving static files — so runApp shouldn't treat a blank
+// RunCommand as a missing one in that case.
+type BuildPlan struct {
+	BaseImage    string
+	InstallSteps []string
+	BuildSteps   []string
+	ArtifactPath string
+	RunCommand   string
+	SelfRunning  bool
+}
+
+// BuildProvider detects a project's language/toolchain, plans its build,
+// and renders the Dockerfile that carries the plan out. This replaces the
+// old hardcoded maven/gradle-only detectBuildTool, in the spirit of
+// Nixpacks providers.
+type BuildProvider interface {
+	Name() string
+	Detect(projectDir string) bool
+	Plan(projectDir string) BuildPlan
+	Dockerfile(plan BuildPlan) string
+}
+
+// buildProviders is walked in order; the first provider whose Detect
+// matches wins. More specific providers (identified by a manifest file)
+// come before the static-site catch-all.
+var buildProviders = []BuildProvider{
+	mavenProvider{},
+	gradleProvider{},
+	nodeProvider{},
+	pythonProvider{},
+	goProvider{},
+	staticProvider{},
+}
+
+// DetectProvider walks buildProviders in priority order and returns the
+// first one that recognizes projectDir.
+func DetectProvider(projectDir string) (BuildProvider, bool) {
+	for _, p := range buildProviders {
+		if p.Detect(projectDir) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// renderDockerfile is the shared layout every provider's Dockerfile method
+// builds on: base image, then copying the checkout in as-is. InstallSteps
+// and BuildSteps are not run here as plain RUN instructions — the classic
+// builder (the API default; see runProviderBuild) has no durable cache
+// across builds without BuildKit's `RUN --mount`, so executeBuild runs
+// them beforehand, against the checkout itself, in a container that
+// mounts the provider's persistent cache volume. By the time this
+// Dockerfile is built, projectDir already holds the resolved dependencies
+// and build artifact, so COPY . . is all that's left to do — there's no
+// RUN left for a manifests-first COPY to protect, so it isn't one.
+func renderDockerfile(plan BuildPlan) string {
+	return "FROM " + plan.BaseImage + "\nWORKDIR /app\nCOPY . .\n"
+}
+
+type mavenProvider struct{}
+
+func (mavenProvider) Name() string { return "maven" }
+
+func (mavenProvider) Detect(projectDir string) bool {
+	return fileExists(filepath.Join(projectDir, "pom.xml"))
+}
+
+func (mavenProvider) Plan(projectDir string) BuildPlan {
+	return BuildPlan{
+		BaseImage:    "maven:3.9-eclipse-temurin-17",
+		InstallSteps: []string{"mvn dependency:go-offline"},
+		BuildSteps:   []string{"mvn clean package"},
+		ArtifactPath: "target",
+	}
+}
+
+func (mavenProvider) Dockerfile(plan BuildPlan) string { return renderDockerfile(plan) }
+
+type gradleProvider struct{}
+
+func (gradleProvider) Name() string { return "gradle" }
+
+func (gradleProvider) Detect(projectDir string) bool {
+	return fileExists(filepath.Join(projectDir, "build.gradle")) ||
+		fileExists(filepath.Join(projectDir, "build.gradle.kts"))
+}
+
+func (gradleProvider) Plan(projectDir string) BuildPlan {
+	return BuildPlan{
+		BaseImage:    "gradle:8-jdk17",
+		InstallSteps: []string{"gradle dependencies"},
+		BuildSteps:   []string{"gradle build"},
+		ArtifactPath: "build/libs",
+	}
+}
+
+func (gradleProvider) Dockerfile(plan BuildPlan) string { return renderDockerfile(plan) }
+
+type nodeProvider struct{}
+
+func (nodeProvider) Name() string { return "node" }
+
+func (nodeProvider) Detect(projectDir string) bool {
+	return fileExists(filepath.Join(projectDir, "package.json"))
+}
+
+// packageManager picks npm/yarn/pnpm based on which lockfile is present,
+// the same signal package managers themselves use to detect each other.
+func (nodeProvider) packageManager(projectDir string) string {
+	if fileExists(filepath.Join(projectDir, "pnpm-lock.yaml")) {
+		return "pnpm"
+	}
+	if fileExists(filepath.Join(projectDir, "yarn.lock")) {
+		return "yarn"
+	}
+	return "npm"
+}
+
+// packageScript reports whether package.json declares the named script,
+// so a step (build, start) is only planned when there's something to run.
+func (nodeProvider) packageScript(projectDir, name string) bool {
+	data, err := os.ReadFile(filepath.Join(projectDir, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return false
+	}
+	_, ok := pkg.Scripts[name]
+	return ok
+}
+
+func (n nodeProvider) hasBuildScript(projectDir string) bool {
+	return n.packageScript(projectDir, "build")
+}
+
+func (n nodeProvider) Plan(projectDir string) BuildPlan {
+	pm := n.packageManager(projectDir)
+	installCmd := pm + " install"
+	if pm == "npm" && fileExists(filepath.Join(projectDir, "package-lock.json")) {
+		installCmd = "npm ci"
+	}
+
+	plan := BuildPlan{
+		BaseImage:    "node:20-slim",
+		InstallSteps: []string{installCmd},
+		ArtifactPath: ".",
+	}
+	if n.hasBuildScript(projectDir) {
+		plan.BuildSteps = []string{pm + " run build"}
+		plan.ArtifactPath = "dist"
+	}
+	if n.packageScript(projectDir, "start") {
+		plan.RunCommand = pm + " start"
+	}
+	return plan
+}
+
+func (nodeProvider) Dockerfile(plan BuildPlan) string { return renderDockerfile(plan) }
+
+type pythonProvider struct{}
+
+func (pythonProvider) Name() string { return "python" }
+
+func (pythonProvider) Detect(projectDir string) bool {
+	return fileExists(filepath.Join(projectDir, "requirements.txt")) ||
+		fileExists(filepath.Join(projectDir, "pyproject.toml")) ||
+		fileExists(filepath.Join(projectDir, "poetry.lock"))
+}
+
+func (pythonProvider) Plan(projectDir string) BuildPlan {
+	var installSteps []string
+	switch {
+	case fileExists(filepath.Join(projectDir, "poetry.lock")):
+		installSteps = []string{"pip install poetry", "poetry install --no-root"}
+	case fileExists(filepath.Join(projectDir, "requirements.txt")):
+		installSteps = []string{"pip install -r requirements.txt"}
+	case fileExists(filepath.Join(projectDir, "pyproject.toml")):
+		installSteps = []string{"pip install ."}
+	}
+
+	return BuildPlan{
+		BaseImage:    "python:3.12-slim",
+		InstallSteps: installSteps,
+		ArtifactPath: ".",
+	}
+}
+
+func (pythonProvider) Dockerfile(plan BuildPlan) string { return renderDockerfile(plan) }
+
+type goProvider struct{}
+
+func (goProvider) Name() string { return "go" }
+
+func (goProvider) Detect(projectDir string) bool {
+	return fileExists(filepath.Join(projectDir, "go.mod"))
+}
+
+func (goProvider) Plan(projectDir string) BuildPlan {
+	return BuildPlan{
+		BaseImage:    "golang:1.22",
+		InstallSteps: []string{"go mod download"},
+		BuildSteps:   []string{"go build -o app ./..."},
+		ArtifactPath: "app",
+		RunCommand:   "./app",
+	}
+}
+
+func (goProvider) Dockerfile(plan BuildPlan) string { return renderDockerfile(plan) }
+
+// staticProvider is the catch-all for plain HTML/CSS/JS sites with no
+// build step, so it must stay last in buildProviders.
+type staticProvider struct{}
+
+func (staticProvider) Name() string { return "static" }
+
+func (staticProvider) Detect(projectDir string) bool {
+	return fileExists(filepath.Join(projectDir, "index.html"))
+}
+
+func (staticProvider) Plan(projectDir string) BuildPlan {
+	return BuildPlan{
+		BaseImage:    "nginx:alpine",
+		ArtifactPath: ".",
+		SelfRunning:  true,
+	}
+}
+
+// Dockerfile doesn't use the shared renderDockerfile: nginx serves
+// /usr/share/nginx/html, not the WORKDIR every other provider's artifact
+// ends up in, so the checkout needs to land there instead of sitting
+// unserved next to nginx's default welcome page.
+func (staticProvider) Dockerfile(plan BuildPlan) string {
+	return "FROM " + plan.BaseImage + "\n" +
+		"WORKDIR /app\nCOPY . .\n" +
+		"COPY . /usr/share/nginx/html\n"
+}
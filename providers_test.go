@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFixture(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write fixture %s: %v", name, err)
+	}
+}
+
+func TestMavenProviderDetect(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "pom.xml", "<project/>")
+
+	p := mavenProvider{}
+	if !p.Detect(dir) {
+		t.Fatal("expected maven provider to detect pom.xml")
+	}
+	plan := p.Plan(dir)
+	if plan.ArtifactPath != "target" {
+		t.Errorf("expected artifact path 'target', got %q", plan.ArtifactPath)
+	}
+}
+
+func TestGradleProviderDetect(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "build.gradle.kts", "plugins {}")
+
+	p := gradleProvider{}
+	if !p.Detect(dir) {
+		t.Fatal("expected gradle provider to detect build.gradle.kts")
+	}
+}
+
+func TestNodeProviderPicksPackageManagerFromLockfile(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "package.json", `{"scripts":{"build":"vite build"}}`)
+	writeFixture(t, dir, "pnpm-lock.yaml", "")
+
+	p := nodeProvider{}
+	if !p.Detect(dir) {
+		t.Fatal("expected node provider to detect package.json")
+	}
+	plan := p.Plan(dir)
+	if plan.InstallSteps[0] != "pnpm install" {
+		t.Errorf("expected pnpm install, got %q", plan.InstallSteps[0])
+	}
+	if len(plan.BuildSteps) != 1 || plan.BuildSteps[0] != "pnpm run build" {
+		t.Errorf("expected build script to be planned, got %v", plan.BuildSteps)
+	}
+}
+
+func TestNodeProviderSkipsBuildStepWhenNoBuildScript(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "package.json", `{"scripts":{"start":"node index.js"}}`)
+
+	plan := nodeProvider{}.Plan(dir)
+	if len(plan.BuildSteps) != 0 {
+		t.Errorf("expected no build steps, got %v", plan.BuildSteps)
+	}
+}
+
+func TestPythonProviderPrefersPoetryLock(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "poetry.lock", "")
+	writeFixture(t, dir, "requirements.txt", "flask")
+
+	p := pythonProvider{}
+	if !p.Detect(dir) {
+		t.Fatal("expected python provider to detect requirements.txt/poetry.lock")
+	}
+	plan := p.Plan(dir)
+	if plan.InstallSteps[len(plan.InstallSteps)-1] != "poetry install --no-root" {
+		t.Errorf("expected poetry install step, got %v", plan.InstallSteps)
+	}
+}
+
+func TestGoProviderDetect(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "go.mod", "module example.com/app\n")
+
+	p := goProvider{}
+	if !p.Detect(dir) {
+		t.Fatal("expected go provider to detect go.mod")
+	}
+}
+
+func TestStaticProviderIsCatchAll(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "index.html", "<html></html>")
+
+	p := staticProvider{}
+	if !p.Detect(dir) {
+		t.Fatal("expected static provider to detect index.html")
+	}
+}
+
+func TestDetectProviderPicksMostSpecificFirst(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "pom.xml", "<project/>")
+	writeFixture(t, dir, "index.html", "<html></html>")
+
+	p, ok := DetectProvider(dir)
+	if !ok {
+		t.Fatal("expected a provider to be detected")
+	}
+	if p.Name() != "maven" {
+		t.Errorf("expected maven to win over static, got %q", p.Name())
+	}
+}
+
+func TestDetectProviderNoMatch(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, ok := DetectProvider(dir); ok {
+		t.Fatal("expected no provider to match an empty project directory")
+	}
+}
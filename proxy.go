@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyDomainSuffix is the host suffix ezdeploy routes on, e.g. a
+// deployment slugged "myapp" answers on "myapp.localhost". Overridable via
+// EZDEPLOY_DOMAIN for setups that aren't purely local.
+var proxyDomainSuffix = envOr("EZDEPLOY_DOMAIN", "localhost")
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newReverseProxy builds the embedded reverse proxy that fronts every
+// running deployment on one address, routing by Host header
+// (<slug>.<proxyDomainSuffix>) to the matching backend container's
+// published port.
+func newReverseProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			slug := strings.TrimSuffix(strings.Split(req.Host, ":")[0], "."+proxyDomainSuffix)
+			target := lookupProxyTarget(slug)
+			if target == nil {
+				return
+			}
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+		},
+	}
+}
+
+func lookupProxyTarget(slug string) *url.URL {
+	for _, d := range deployments.list() {
+		if d.Host != slug || d.Status != DeploymentRunning {
+			continue
+		}
+		target, err := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", d.Port))
+		if err != nil {
+			return nil
+		}
+		return target
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxConcurrentBuilds bounds how many deploys run at once, regardless of
+// whether they came in over the HTTP API, git push, or a webhook.
+const maxConcurrentBuilds = 4
+
+type deployJob struct {
+	req          RepoRequest
+	repoName     string
+	deploymentID string
+	deployLog    *deploymentLog
+	sf           *StreamFormatter
+}
+
+var deployQueue = make(chan deployJob, 100)
+
+func init() {
+	for i := 0; i < maxConcurrentBuilds; i++ {
+		go deployWorker()
+	}
+}
+
+func deployWorker() {
+	for job := range deployQueue {
+		runDeploy(job.req, job.repoName, job.deploymentID, job.deployLog, job.sf)
+	}
+}
+
+// enqueueDeploy is the single funnel every deploy trigger (the HTTP API,
+// git push, GitHub/GitLab webhooks) goes through, so concurrency limits
+// and streaming logs behave the same no matter how the deploy was
+// triggered. It does no authentication itself: the SSH server and the
+// webhook handlers check their own credentials before calling it, and
+// the HTTP API checks none.
+func enqueueDeploy(req RepoRequest, repoName string) string {
+	deploymentID := fmt.Sprintf("%s-%d", repoName, time.Now().UnixNano())
+	deployLog := deployLogs.start(deploymentID)
+	sf := NewStreamFormatter(deployLog)
+
+	deployQueue <- deployJob{
+		req:          req,
+		repoName:     repoName,
+		deploymentID: deploymentID,
+		deployLog:    deployLog,
+		sf:           sf,
+	}
+	return deploymentID
+}
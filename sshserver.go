@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// gitReposDir holds the bare repos git-receive-pack pushes land in, one
+// per slug, next to the build-output directory executeBuild creates.
+const gitReposDir = "git-repos"
+
+// KeyStore looks up authorized SSH public keys by fingerprint, so the
+// embedded git-push server can answer "is this key allowed" without the
+// auth check caring where keys actually live.
+type KeyStore interface {
+	Authorized(fingerprint string) (user string, ok bool)
+}
+
+// memoryKeyStore is the simplest KeyStore: a fixed fingerprint->user map
+// configured in-process. Swap in one backed by a database or an identity
+// provider without touching startSSHServer.
+type memoryKeyStore struct {
+	keys map[string]string
+}
+
+func newMemoryKeyStore() *memoryKeyStore {
+	return &memoryKeyStore{keys: make(map[string]string)}
+}
+
+func (s *memoryKeyStore) Add(user string, pubKey ssh.PublicKey) {
+	s.keys[fingerprint(pubKey)] = user
+}
+
+func (s *memoryKeyStore) Authorized(fp string) (string, bool) {
+	user, ok := s.keys[fp]
+	return user, ok
+}
+
+func fingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var sshKeyStore KeyStore = newMemoryKeyStore()
+
+// authorizedKeysFile points at an OpenSSH authorized_keys-style file
+// (one public key per line, the trailing comment field taken as the
+// user) that seeds sshKeyStore on startup. Configured via an env var so
+// who's allowed to `git push` isn't baked into source.
+var authorizedKeysFile = os.Getenv("EZDEPLOY_AUTHORIZED_KEYS_FILE")
+
+// loadAuthorizedKeys seeds sshKeyStore from an authorized_keys-style
+// file, so PublicKeyCallback has something other than an empty map to
+// check pushed keys against.
+func loadAuthorizedKeys(path string) error {
+	ks, ok := sshKeyStore.(*memoryKeyStore)
+	if !ok {
+		return fmt.Errorf("authorized keys file configured but sshKeyStore isn't a memoryKeyStore")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read authorized keys file: %v", err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return fmt.Errorf("failed to parse authorized key: %v", err)
+		}
+		user := strings.TrimSpace(comment)
+		if user == "" {
+			user = "git"
+		}
+		ks.Add(user, key)
+	}
+	return scanner.Err()
+}
+
+// startSSHServer runs an embedded SSH server that accepts git-receive-pack
+// for `git push ezdeploy@host:<slug>`, mirroring the Deis git-hook
+// pattern: a push is just another way to reach enqueueDeploy, the same
+// build queue deployHandler and the webhooks use.
+func startSSHServer(addr string) error {
+	if authorizedKeysFile != "" {
+		if err := loadAuthorizedKeys(authorizedKeysFile); err != nil {
+			return fmt.Errorf("failed to load authorized keys: %v", err)
+		}
+	} else {
+		fmt.Println("EZDEPLOY_AUTHORIZED_KEYS_FILE not set; git-push SSH server will reject every key")
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			user, ok := sshKeyStore.Authorized(fingerprint(key))
+			if !ok {
+				return nil, fmt.Errorf("unauthorized key")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"user": user}}, nil
+		},
+	}
+
+	signer, err := generateHostKey()
+	if err != nil {
+		return err
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for ssh: %v", err)
+	}
+
+	fmt.Println("Starting git-push SSH server on", addr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println("ssh accept error:", err)
+			continue
+		}
+		go handleSSHConn(conn, config)
+	}
+}
+
+func generateHostKey() (ssh.Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ssh host key: %v", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+func handleSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		fmt.Println("ssh handshake failed:", err)
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go handleSSHSession(channel, requests, sshConn.Permissions)
+	}
+}
+
+// handleSSHSession only understands one command: git-receive-pack for a
+// slug. It runs the real `git-receive-pack` binary against that slug's
+// bare repo with the channel wired up as its stdin/stdout, the same way
+// the real `git` client expects to speak to an SSH remote, so the pkt-line
+// handshake and the pushed objects are handled by git itself rather than
+// reimplemented here. The one thing it does read itself is the ref update
+// commands that precede the packfile, so it knows which branch was just
+// pushed — git-receive-pack never reports that back on its own. Once the
+// push lands, it enqueues a build cloned straight from that bare repo, for
+// that branch.
+func handleSSHSession(channel ssh.Channel, requests <-chan *ssh.Request, perms *ssh.Permissions) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		command := string(req.Payload[4:]) // strip the uint32 length prefix
+		req.Reply(true, nil)
+
+		if !strings.HasPrefix(command, "git-receive-pack") {
+			fmt.Fprintln(channel.Stderr(), "only git-receive-pack is supported")
+			return
+		}
+
+		slug := parseGitReceivePackSlug(command)
+		user := ""
+		if perms != nil {
+			user = perms.Extensions["user"]
+		}
+
+		repoPath, err := ensureBareRepo(slug)
+		if err != nil {
+			fmt.Fprintf(channel.Stderr(), "failed to prepare repo for %s: %v\n", slug, err)
+			return
+		}
+
+		// stdin is read through this buffered reader rather than channel
+		// directly: peekPushedBranch has to consume the ref-update
+		// pkt-lines to find the branch name, and a bufio.Reader lets the
+		// packfile bytes that follow stay put, unread, until
+		// git-receive-pack itself reads them back out of the same reader.
+		stdin := bufio.NewReaderSize(channel, 64*1024)
+		branch, err := peekPushedBranch(stdin)
+		if err != nil {
+			fmt.Fprintf(channel.Stderr(), "failed to parse push: %v\n", err)
+			return
+		}
+
+		cmd := exec.Command("git", "receive-pack", repoPath)
+		cmd.Stdin = stdin
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(channel.Stderr(), "git-receive-pack failed: %v\n", err)
+			return
+		}
+
+		if branch == "" {
+			fmt.Fprintln(channel.Stderr(), "push had no branch update to deploy")
+			return
+		}
+
+		deploymentID := enqueueDeploy(RepoRequest{RepoURL: repoPath, Branch: branch}, slug)
+		fmt.Fprintf(channel, "Deploy %s queued for %s@%s (pushed by %s)\n", deploymentID, slug, branch, user)
+		return
+	}
+}
+
+// peekPushedBranch reads the git-receive-pack protocol's ref-update
+// pkt-lines off r up to the flush-pkt that ends them — the part of the
+// push that names old/new SHAs and the ref being updated — and returns the
+// branch of the first one that isn't a delete (new SHA all zeros). r must
+// be a *bufio.Reader so the packfile bytes that follow the flush-pkt are
+// left in its buffer for the caller to hand off to git-receive-pack
+// unconsumed.
+func peekPushedBranch(r *bufio.Reader) (string, error) {
+	branch := ""
+	for {
+		line, flush, err := readPktLine(r)
+		if err != nil {
+			return "", err
+		}
+		if flush {
+			return branch, nil
+		}
+
+		fields := strings.SplitN(strings.TrimRight(string(line), "\n"), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		newSHA, ref := fields[1], fields[2]
+		if i := strings.IndexByte(ref, 0); i >= 0 {
+			ref = ref[:i] // strip the capabilities list off the first command line
+		}
+		if strings.Trim(newSHA, "0") == "" {
+			continue // branch delete, nothing to deploy
+		}
+		if branch == "" {
+			branch = strings.TrimPrefix(ref, "refs/heads/")
+		}
+	}
+}
+
+// readPktLine reads one git pkt-line from r: a 4-byte hex length prefix
+// (including itself) followed by that many bytes of payload. A length of
+// "0000" is a flush-pkt, reported via the flush return instead of data.
+func readPktLine(r *bufio.Reader) (data []byte, flush bool, err error) {
+	var lenHex [4]byte
+	if _, err := io.ReadFull(r, lenHex[:]); err != nil {
+		return nil, false, fmt.Errorf("failed to read pkt-line length: %v", err)
+	}
+	n, err := strconv.ParseInt(string(lenHex[:]), 16, 32)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pkt-line length %q: %v", lenHex, err)
+	}
+	if n == 0 {
+		return nil, true, nil
+	}
+
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, false, fmt.Errorf("failed to read pkt-line payload: %v", err)
+	}
+	return payload, false, nil
+}
+
+// ensureBareRepo returns the bare repo path backing slug, running
+// `git init --bare` the first time a slug is pushed.
+func ensureBareRepo(slug string) (string, error) {
+	if err := os.MkdirAll(gitReposDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create git repos directory: %v", err)
+	}
+
+	repoPath := filepath.Join(gitReposDir, slug+".git")
+	if fileExists(repoPath) {
+		return repoPath, nil
+	}
+
+	if err := exec.Command("git", "init", "--bare", repoPath).Run(); err != nil {
+		return "", fmt.Errorf("failed to init bare repo: %v", err)
+	}
+	return repoPath, nil
+}
+
+// parseGitReceivePackSlug extracts <slug> from `git-receive-pack '<slug>'`.
+func parseGitReceivePackSlug(command string) string {
+	parts := strings.SplitN(command, " ", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(parts[1]), "'")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// StreamMessage is a single JSON-lines event emitted while a deployment
+// progresses through its phases (clone, dockerfile, build, run, extract).
+type StreamMessage struct {
+	Status   string `json:"status"`
+	Stream   string `json:"stream,omitempty"`
+	Progress string `json:"progress,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StreamFormatter writes StreamMessage events as newline-delimited JSON to
+// an underlying writer, in the spirit of Docker's pkg/streamformatter.
+type StreamFormatter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStreamFormatter(w io.Writer) *StreamFormatter {
+	return &StreamFormatter{w: w}
+}
+
+func (sf *StreamFormatter) write(msg StreamMessage) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	_, err = sf.w.Write(line)
+	return err
+}
+
+// Status reports the start/end of a named phase, e.g. "cloning", "building".
+func (sf *StreamFormatter) Status(status string) error {
+	return sf.write(StreamMessage{Status: status})
+}
+
+// Stream forwards a raw output line (container logs, build tool output).
+func (sf *StreamFormatter) Stream(status, line string) error {
+	return sf.write(StreamMessage{Status: status, Stream: line})
+}
+
+// Progress reports a coarse progress indicator for a phase.
+func (sf *StreamFormatter) Progress(status, progress string) error {
+	return sf.write(StreamMessage{Status: status, Progress: progress})
+}
+
+// Error reports a terminal failure for the deployment.
+func (sf *StreamFormatter) Error(status string, err error) error {
+	return sf.write(StreamMessage{Status: status, Error: err.Error()})
+}
+
+// deploymentLog buffers every StreamMessage line emitted for a deployment ID
+// and fans it out to any number of live subscribers (SSE/WebSocket clients),
+// replaying everything seen so far to late joiners.
+type deploymentLog struct {
+	mu     sync.Mutex
+	lines  []string
+	subs   []chan string
+	closed bool
+}
+
+func newDeploymentLog() *deploymentLog {
+	return &deploymentLog{}
+}
+
+func (d *deploymentLog) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	line := string(p)
+	d.lines = append(d.lines, line)
+	for _, sub := range d.subs {
+		select {
+		case sub <- line:
+		default:
+			// slow subscriber, drop the line rather than block the build
+		}
+	}
+	return len(p), nil
+}
+
+func (d *deploymentLog) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	for _, sub := range d.subs {
+		close(sub)
+	}
+	d.subs = nil
+}
+
+// subscribe returns a channel that first replays buffered lines, then
+// streams new ones as they arrive. The channel is closed once the
+// deployment finishes. It's sized to hold every buffered line up front so
+// replaying them while holding d.mu (done below, to keep replay and
+// "subscribe to new lines" atomic) can never block on a reader that
+// hasn't started ranging over the channel yet.
+func (d *deploymentLog) subscribe() <-chan string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch := make(chan string, len(d.lines)+256)
+	for _, line := range d.lines {
+		ch <- line
+	}
+	if d.closed {
+		close(ch)
+		return ch
+	}
+	d.subs = append(d.subs, ch)
+	return ch
+}
+
+// deploymentLogRegistry keeps track of deploymentLogs by deployment ID so
+// the SSE/WebSocket endpoints can find the log started by deployHandler.
+type deploymentLogRegistry struct {
+	mu   sync.Mutex
+	logs map[string]*deploymentLog
+}
+
+var deployLogs = &deploymentLogRegistry{logs: make(map[string]*deploymentLog)}
+
+func (r *deploymentLogRegistry) start(id string) *deploymentLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log := newDeploymentLog()
+	r.logs[id] = log
+	return log
+}
+
+func (r *deploymentLogRegistry) get(id string) (*deploymentLog, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log, ok := r.logs[id]
+	return log, ok
+}
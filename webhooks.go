@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// githubWebhookSecret and gitlabWebhookSecret authenticate inbound
+// webhook calls; configured via env vars so the secret never lives in
+// source.
+var githubWebhookSecret = os.Getenv("EZDEPLOY_GITHUB_WEBHOOK_SECRET")
+var gitlabWebhookSecret = os.Getenv("EZDEPLOY_GITLAB_WEBHOOK_SECRET")
+
+type githubPushEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+		Name     string `json:"name"`
+	} `json:"repository"`
+}
+
+// githubWebhookHandler verifies X-Hub-Signature-256, parses a GitHub push
+// event, and enqueues a deploy for the pushed branch through the same
+// queue deployHandler uses.
+func githubWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if !verifyHubSignature(githubWebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var event githubPushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse push event: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	branch := strings.TrimPrefix(event.Ref, "refs/heads/")
+	fmt.Println("github webhook push to", event.Repository.Name, "branch", branch)
+
+	deploymentID := enqueueDeploy(RepoRequest{RepoURL: event.Repository.CloneURL, Branch: branch}, event.Repository.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"deploymentId": deploymentID})
+}
+
+type gitlabPushEvent struct {
+	Ref     string `json:"ref"`
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+		Name       string `json:"name"`
+	} `json:"project"`
+}
+
+// gitlabWebhookHandler verifies the X-Gitlab-Token header, parses a
+// GitLab push event, and enqueues a deploy for the pushed branch.
+func gitlabWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if gitlabWebhookSecret == "" || !hmac.Equal([]byte(r.Header.Get("X-Gitlab-Token")), []byte(gitlabWebhookSecret)) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var event gitlabPushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse push event: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	branch := strings.TrimPrefix(event.Ref, "refs/heads/")
+	fmt.Println("gitlab webhook push to", event.Project.Name, "branch", branch)
+
+	deploymentID := enqueueDeploy(RepoRequest{RepoURL: event.Project.GitHTTPURL, Branch: branch}, event.Project.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"deploymentId": deploymentID})
+}
+
+// verifyHubSignature checks GitHub's X-Hub-Signature-256 header, an
+// HMAC-SHA256 of the raw body keyed by the shared webhook secret.
+func verifyHubSignature(secret string, body []byte, header string) bool {
+	if secret == "" || header == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header))
+}